@@ -0,0 +1,209 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+)
+
+// archiveEntry is a file or directory to be added to a tar or zip archive:
+// relPath is slash-separated and relative to the archived directory, absPath
+// is the path used to re-open it through the http.FileSystem it came from.
+// isDir entries (only emitted for empty directories, since a non-empty one
+// is already implied by its children's paths) carry no absPath.
+type archiveEntry struct {
+	relPath string
+	absPath string
+	isDir   bool
+}
+
+// collectEntries walks dirName depth-first, in sorted order, and returns
+// every regular file found under it, plus a directory entry for every empty
+// subdirectory so it isn't silently dropped from the archive. Readdir
+// reports symlinks unresolved (IsDir false even for a symlink-to-directory),
+// so those are resolved with a separate Open+Stat to decide whether to
+// recurse into them or add them as a leaf entry.
+func collectEntries(fs http.FileSystem, dirName string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	var walk func(rel, abs string) error
+	walk = func(rel, abs string) error {
+		d, err := fs.Open(abs)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		var infos []os.FileInfo
+		for {
+			batch, err := d.Readdir(100)
+			if err != nil || len(batch) == 0 {
+				break
+			}
+			infos = append(infos, batch...)
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		if len(infos) == 0 && rel != "" {
+			entries = append(entries, archiveEntry{relPath: rel, isDir: true})
+			return nil
+		}
+		for _, info := range infos {
+			childRel := path.Join(rel, info.Name())
+			childAbs := path.Join(abs, info.Name())
+			isDir := info.IsDir()
+			if !isDir && info.Mode()&os.ModeSymlink != 0 {
+				isDir, err = resolvesToDir(fs, childAbs)
+				if err != nil {
+					log.Printf("archive: skipping symlink %v: %v", childAbs, err)
+					continue
+				}
+			}
+			if isDir {
+				if err := walk(childRel, childAbs); err != nil {
+					return err
+				}
+				continue
+			}
+			entries = append(entries, archiveEntry{relPath: childRel, absPath: childAbs})
+		}
+		return nil
+	}
+	if err := walk("", dirName); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// resolvesToDir reports whether absPath, followed through any symlink,
+// points at a directory.
+func resolvesToDir(fs http.FileSystem, absPath string) (bool, error) {
+	f, err := fs.Open(absPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// archiveName returns the download filename (sans extension) for dirName.
+func archiveName(dirName string) string {
+	base := path.Base(dirName)
+	if base == "" || base == "." || base == "/" {
+		return "archive"
+	}
+	return base
+}
+
+func serveTar(w http.ResponseWriter, fs http.FileSystem, dirName string) {
+	entries, err := collectEntries(fs, dirName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName(dirName)+".tar"))
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, e := range entries {
+		if err := addTarEntry(tw, fs, e); err != nil {
+			log.Printf("tar: skipping %v: %v", e.relPath, err)
+		}
+	}
+}
+
+func addTarEntry(tw *tar.Writer, fs http.FileSystem, e archiveEntry) error {
+	if e.isDir {
+		return tw.WriteHeader(&tar.Header{
+			Name:     e.relPath + "/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+		})
+	}
+	f, err := fs.Open(e.absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = e.relPath
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func serveZip(w http.ResponseWriter, fs http.FileSystem, dirName string) {
+	entries, err := collectEntries(fs, dirName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName(dirName)+".zip"))
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	for _, e := range entries {
+		if err := addZipEntry(zw, fs, e); err != nil {
+			log.Printf("zip: skipping %v: %v", e.relPath, err)
+		}
+	}
+}
+
+func addZipEntry(zw *zip.Writer, fs http.FileSystem, e archiveEntry) error {
+	if e.isDir {
+		_, err := zw.Create(e.relPath + "/")
+		return err
+	}
+	f, err := fs.Open(e.absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = e.relPath
+	hdr.Method = zip.Deflate
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// serveArchive streams dirName as a deterministic tar or zip archive,
+// format being "tar" or "zip".
+func serveArchive(w http.ResponseWriter, fs http.FileSystem, dirName, format string) {
+	switch format {
+	case "tar":
+		serveTar(w, fs, dirName)
+	case "zip":
+		serveZip(w, fs, dirName)
+	default:
+		http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+	}
+}
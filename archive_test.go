@@ -0,0 +1,79 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestArchiveName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/", "archive"},
+		{"", "archive"},
+		{"/sub", "sub"},
+		{"/sub/dir", "dir"},
+	}
+	for _, tt := range tests {
+		if got := archiveName(tt.in); got != tt.want {
+			t.Errorf("archiveName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCollectEntries(t *testing.T) {
+	root, err := ioutil.TempDir("", "fafserver-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "sub"), filepath.Join(root, "sub-link")); err != nil {
+		t.Skipf("could not create symlink, skipping: %v", err)
+	}
+
+	entries, err := collectEntries(http.Dir(root), "/")
+	if err != nil {
+		t.Fatalf("collectEntries: %v", err)
+	}
+	var got []string
+	isDir := map[string]bool{}
+	for _, e := range entries {
+		got = append(got, e.relPath)
+		isDir[e.relPath] = e.isDir
+	}
+	sort.Strings(got)
+	want := []string{"a.txt", "empty", "sub-link/b.txt", "sub/b.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("collectEntries relPaths = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("collectEntries relPaths[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if !isDir["empty"] {
+		t.Error(`collectEntries entry "empty" should be marked isDir, so it isn't dropped from the archive`)
+	}
+	if isDir["a.txt"] {
+		t.Error(`collectEntries entry "a.txt" should not be marked isDir`)
+	}
+}
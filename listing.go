@@ -0,0 +1,121 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultListingTemplate renders a directory listing as an HTML table,
+// escaping file names, and linking the parent directory and the tar/zip
+// archive forms of the current one. It is used unless -listingTemplate
+// points at a user-supplied replacement.
+const defaultListingTemplate = `<!DOCTYPE html>
+<html>
+<head><title>{{.Path}}</title></head>
+<body>
+<p><a href="?format=tar">download as .tar</a> | <a href="?format=zip">download as .zip</a></p>
+{{if .Parent}}<p><a href="{{.Parent}}">../</a></p>{{end}}
+<table>
+<tr><th align="left">Name</th><th align="right">Size</th><th align="left">Last modified</th></tr>
+{{range .Entries}}<tr><td><a href="{{.Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td align="right">{{.Size}}</td><td>{{.ModTime}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+
+var listingTmpl *template.Template
+
+// initListingTemplate parses -listingTemplate if set, or else the built-in
+// defaultListingTemplate. It must be called before the server starts
+// handling requests.
+func initListingTemplate() {
+	if *flagListingTemplate == "" {
+		listingTmpl = template.Must(template.New("listing").Parse(defaultListingTemplate))
+		return
+	}
+	t, err := template.ParseFiles(*flagListingTemplate)
+	if err != nil {
+		log.Fatalf("could not parse -listingTemplate %v: %v", *flagListingTemplate, err)
+	}
+	listingTmpl = t
+}
+
+// dirEntry is the per-file data made available to the listing template.
+type dirEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// listingData is the data made available to the listing template.
+type listingData struct {
+	Path    string
+	Parent  string
+	Entries []dirEntry
+}
+
+type sortedFiles []os.FileInfo
+
+func (s sortedFiles) Len() int { return len(s) }
+
+func (s sortedFiles) Less(i, j int) bool {
+	return strings.ToLower(s[i].Name()) < strings.ToLower(s[j].Name())
+}
+
+func (s sortedFiles) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// parentOf returns the link to the parent directory of urlPath, or "" if
+// urlPath is already the root.
+func parentOf(urlPath string) string {
+	trimmed := strings.TrimSuffix(urlPath, "/")
+	if trimmed == "" {
+		return ""
+	}
+	dir := path.Dir(trimmed)
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return dir
+}
+
+func sortedDirList(w http.ResponseWriter, r *http.Request, f http.File) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var sdirs sortedFiles
+	for {
+		dirs, err := f.Readdir(100)
+		if err != nil || len(dirs) == 0 {
+			break
+		}
+		sdirs = append(sdirs, dirs...)
+	}
+	sort.Sort(sdirs)
+
+	entries := make([]dirEntry, 0, len(sdirs))
+	for _, d := range sdirs {
+		entries = append(entries, dirEntry{
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			Size:    d.Size(),
+			ModTime: d.ModTime(),
+		})
+	}
+	data := listingData{
+		Path:    r.URL.Path,
+		Parent:  parentOf(r.URL.Path),
+		Entries: entries,
+	}
+	if err := listingTmpl.Execute(w, data); err != nil {
+		log.Printf("could not render directory listing for %v: %v", r.URL.Path, err)
+	}
+}
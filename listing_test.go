@@ -0,0 +1,21 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import "testing"
+
+func TestParentOf(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/", ""},
+		{"/sub/", "/"},
+		{"/sub/dir/", "/sub/"},
+		{"/sub/dir", "/sub/"},
+	}
+	for _, tt := range tests {
+		if got := parentOf(tt.in); got != tt.want {
+			t.Errorf("parentOf(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
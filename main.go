@@ -3,33 +3,149 @@
 // fafserver (fire and forget server) starts an HTTPS server on a random port,
 // protected by randomly generated username and password for HTTP basic auth, and
 // which dies after the specified time.
-// It requires the HTTPS cert and key "key.pem" and "cert.pem" in $HOME/keys.
+// It requires the HTTPS cert and key "key.pem" and "cert.pem" in $HOME/keys,
+// unless -autocert is used to obtain one from Let's Encrypt instead.
+// It can optionally also require clients to present a certificate signed by
+// a given CA, see -clientCAFile, and accept uploads instead of only serving
+// files, see -writable. Directories can be downloaded in one shot as a tar
+// or zip archive with a "?format=tar" or "?format=zip" query parameter, or
+// browsed as an HTML listing, optionally rendered with a custom
+// -listingTemplate.
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/mpl/basicauth"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/netutil"
 )
 
 const idstring = "http://golang.org/pkg/http/#ListenAndServe"
 
 var (
-	flagHost = flag.String("host", "", "Optional hostname to listen on. The port will still be random.")
-	flagDie  = flag.Duration("die", 24*time.Hour, "Die after the specified time.")
-	flagHelp = flag.Bool("h", false, "show this help")
+	flagHost            = flag.String("host", "", "Optional hostname to listen on. The port will still be random.")
+	flagDie             = flag.Duration("die", 24*time.Hour, "Die after the specified time.")
+	flagHelp            = flag.Bool("h", false, "show this help")
+	flagClientCAFile    = flag.String("clientCAFile", "", "If set, path to a PEM file of CA certificates. Clients must then present a certificate signed by one of these CAs, on top of the usual basic auth.")
+	flagClientCert      = flag.String("clientCert", "", "Path to the server's own HTTPS cert, for use with -clientCAFile. Defaults to $HOME/keys/cert.pem.")
+	flagClientKey       = flag.String("clientKey", "", "Path to the server's own HTTPS key, for use with -clientCAFile. Defaults to $HOME/keys/key.pem.")
+	flagMaxConns        = flag.Int("maxConns", 0, "If > 0, the maximum number of simultaneous connections accepted by the listener.")
+	flagMinTLS          = flag.String("minTLS", "tls1.2", "Minimum TLS version to accept: tls1.0, tls1.1, tls1.2, or tls1.3.")
+	flagCipherSuites    = flag.String("cipherSuites", "", "Comma-separated allowlist of TLS cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). If empty, the Go defaults for -minTLS are used. Below -minTLS=tls1.3, must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, or HTTP/2 refuses to enable itself.")
+	flagAutocert        = flag.String("autocert", "", "If set, the hostname to request a Let's Encrypt certificate for via ACME, instead of using the cert.pem/key.pem pair in $HOME/keys. Requires -host to match, and port 80 (or -autocertAddr) to be reachable from the internet for the HTTP-01 challenge. Not compatible with -clientCAFile.")
+	flagAutocertAddr    = flag.String("autocertAddr", ":80", "Address the ACME HTTP-01 challenge helper listens on, when -autocert is set.")
+	flagWritable        = flag.Bool("writable", false, "Allow authenticated clients to PUT/POST files into -uploadDir, turning fafserver into a drop box.")
+	flagUploadDir       = flag.String("uploadDir", "", "Directory uploads are stored under, when -writable is set. Defaults to the current directory.")
+	flagListingTemplate = flag.String("listingTemplate", "", "Path to an optional html/template file to render directory listings with, instead of the built-in one.")
 )
 
+// tlsVersions maps the -minTLS flag values to their crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"tls1.0": tls.VersionTLS10,
+	"tls1.1": tls.VersionTLS11,
+	"tls1.2": tls.VersionTLS12,
+	"tls1.3": tls.VersionTLS13,
+}
+
+// cipherSuites maps cipher suite names, as they appear in crypto/tls, to
+// their IDs, for use with -cipherSuites. Only the suites considered safe to
+// offer at all (i.e. not RC4 or 3DES) are listed.
+//
+// The TLS 1.3 suites (TLS_AES_128_GCM_SHA256 and friends) are listed for
+// completeness but are inert here: crypto/tls.Config.CipherSuites only
+// constrains the TLS 1.2 and below handshake, never 1.3, whose suite is
+// always chosen by the stdlib.
+var cipherSuites = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_AES_128_GCM_SHA256":                  tls.TLS_AES_128_GCM_SHA256,
+	"TLS_AES_256_GCM_SHA384":                  tls.TLS_AES_256_GCM_SHA384,
+	"TLS_CHACHA20_POLY1305_SHA256":            tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// http2RequiredCipherSuites are the suites golang.org/x/net/http2.ConfigureServer
+// requires at least one of, whenever TLSConfig.CipherSuites is non-nil and
+// MinVersion is below TLS 1.3 (see http2's ConfigureServer doc and
+// http2.isBadCipher): without one of these, ConfigureServer refuses to
+// enable HTTP/2 at all.
+var http2RequiredCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// parseMinTLSVersion turns a -minTLS flag value into the crypto/tls constant.
+func parseMinTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, want one of tls1.0, tls1.1, tls1.2, tls1.3", s)
+	}
+	return v, nil
+}
+
+// checkHTTP2CipherSuites returns an error if suites would make
+// http2.ConfigureServer refuse to enable HTTP/2, given minVersion.
+func checkHTTP2CipherSuites(minVersion uint16, suites []uint16) error {
+	if len(suites) == 0 || minVersion >= tls.VersionTLS13 {
+		return nil
+	}
+	for _, required := range http2RequiredCipherSuites {
+		for _, id := range suites {
+			if id == required {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("-cipherSuites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 below -minTLS=tls1.3, or HTTP/2 refuses to enable itself")
+}
+
+// parseCipherSuites turns a comma-separated -cipherSuites flag value into
+// the corresponding crypto/tls IDs. An empty string yields a nil slice,
+// letting crypto/tls pick its own default suites.
+func parseCipherSuites(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or disallowed cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// shutdownGrace is how long we give in-flight requests to finish once a
+// shutdown has been requested, before forcibly cancelling them.
+const shutdownGrace = 10 * time.Second
+
 var (
 	rootdir, _ = os.Getwd()
 	up         *basicauth.UserPass
@@ -59,41 +175,6 @@ func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.Handl
 	}
 }
 
-type sortedFiles []os.FileInfo
-
-func (s sortedFiles) Len() int { return len(s) }
-
-func (s sortedFiles) Less(i, j int) bool {
-	return strings.ToLower(s[i].Name()) < strings.ToLower(s[j].Name())
-}
-
-func (s sortedFiles) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func sortedDirList(w http.ResponseWriter, f http.File) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, "<pre>\n")
-	var sdirs sortedFiles
-	for {
-		dirs, err := f.Readdir(100)
-		if err != nil || len(dirs) == 0 {
-			break
-		}
-		sdirs = append(sdirs, dirs...)
-	}
-	sort.Sort(sdirs)
-	for _, d := range sdirs {
-		name := d.Name()
-		if d.IsDir() {
-			name += "/"
-		}
-		// TODO htmlescape
-		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", name, name)
-	}
-	fmt.Fprintf(w, "</pre>\n")
-}
-
 // modtime is the modification time of the resource to be served, or IsZero().
 // return value is whether this request is now complete.
 func checkLastModified(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
@@ -111,6 +192,18 @@ func checkLastModified(w http.ResponseWriter, r *http.Request, modtime time.Time
 	return false
 }
 
+// localRedirect gives a Moved Permanently response, preserving the query
+// string. Unlike http.Redirect, it does not turn a relative newPath into an
+// absolute one.
+// Copied from stdlib's net/http.
+func localRedirect(w http.ResponseWriter, r *http.Request, newPath string) {
+	if q := r.URL.RawQuery; q != "" {
+		newPath += "?" + q
+	}
+	w.Header().Set("Location", newPath)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
 // copied from stdlib, and modified to server sorted listing
 // name is '/'-separated, not filepath.Separator.
 func serveFile(w http.ResponseWriter, r *http.Request, fs http.FileSystem, name string) {
@@ -131,6 +224,19 @@ func serveFile(w http.ResponseWriter, r *http.Request, fs http.FileSystem, name
 		return
 	}
 
+	if d.IsDir() {
+		// redirect to the slash-terminated form so the listing's
+		// relative links resolve against the right base.
+		if url := r.URL.Path; !strings.HasSuffix(url, "/") {
+			localRedirect(w, r, path.Base(url)+"/")
+			return
+		}
+		if format := r.URL.Query().Get("format"); format == "tar" || format == "zip" {
+			serveArchive(w, fs, name, format)
+			return
+		}
+	}
+
 	// use contents of index.html for directory, if present
 	if d.IsDir() {
 		index := name + indexPage
@@ -151,7 +257,7 @@ func serveFile(w http.ResponseWriter, r *http.Request, fs http.FileSystem, name
 		if checkLastModified(w, r, d.ModTime()) {
 			return
 		}
-		sortedDirList(w, f)
+		sortedDirList(w, r, f)
 		return
 	}
 
@@ -160,6 +266,14 @@ func serveFile(w http.ResponseWriter, r *http.Request, fs http.FileSystem, name
 }
 
 func myFileServer(w http.ResponseWriter, r *http.Request, url string) {
+	if r.Method == "PUT" || r.Method == "POST" {
+		if !*flagWritable {
+			http.Error(w, "uploads are disabled, see -writable", http.StatusForbidden)
+			return
+		}
+		handleUpload(w, r, url)
+		return
+	}
 	dir, file := filepath.Split(filepath.Join(rootdir, url))
 	serveFile(w, r, http.Dir(dir), file)
 }
@@ -191,6 +305,105 @@ func randToken(size int) (string, error) {
 	return fmt.Sprintf("%x", buf), nil
 }
 
+// certPath returns flagVal if set, or else the given default filename
+// under $HOME/keys.
+func certPath(flagVal, defaultName string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	return filepath.Join(os.Getenv("HOME"), "keys", defaultName)
+}
+
+// tlsSecurityParams parses -minTLS and -cipherSuites, common to both the
+// static-keypair and the autocert TLS configs, and rejects -cipherSuites
+// combinations that would otherwise make http2.ConfigureServer silently
+// refuse to enable HTTP/2 later on (see checkHTTP2CipherSuites).
+func tlsSecurityParams() (minVersion uint16, suites []uint16, err error) {
+	minVersion, err = parseMinTLSVersion(*flagMinTLS)
+	if err != nil {
+		return 0, nil, err
+	}
+	suites, err = parseCipherSuites(*flagCipherSuites)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkHTTP2CipherSuites(minVersion, suites); err != nil {
+		return 0, nil, err
+	}
+	return minVersion, suites, nil
+}
+
+// buildTLSConfig loads the server's own certificate and, if -clientCAFile is
+// set, configures the returned *tls.Config to require and verify a client
+// certificate signed by one of the CAs in that file. If -clientCAFile is not
+// set, basic auth (see initUserPass) remains the only line of defense.
+func buildTLSConfig() (*tls.Config, error) {
+	certFile := certPath(*flagClientCert, "cert.pem")
+	keyFile := certPath(*flagClientKey, "key.pem")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %v and %v: %v", certFile, keyFile, err)
+	}
+	minVersion, suites, err := tlsSecurityParams()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+	if *flagClientCAFile == "" {
+		return config, nil
+	}
+	pemCerts, err := ioutil.ReadFile(*flagClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %v: %v", *flagClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("no valid certificates found in %v", *flagClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// buildAutocertConfig returns a *tls.Config and the autocert.Manager backing
+// it, which obtains and renews a real certificate for hostname through ACME
+// instead of requiring a pre-provisioned cert.pem/key.pem. The manager's
+// HTTP-01 challenges must be served separately, see -autocertAddr.
+func buildAutocertConfig(hostname string) (*tls.Config, *autocert.Manager, error) {
+	if *flagClientCAFile != "" {
+		return nil, nil, fmt.Errorf("-clientCAFile is not supported together with -autocert: autocert.Manager.GetCertificate always answers ACME and regular handshakes alike, so mutual-TLS could not actually be enforced")
+	}
+	minVersion, suites, err := tlsSecurityParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hostname),
+		Cache:      autocert.DirCache(filepath.Join(os.Getenv("HOME"), "keys", "acme")),
+	}
+	config := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+	}
+	return config, m, nil
+}
+
+// shutdown gracefully stops srv, giving in-flight requests up to
+// shutdownGrace to complete before they get cancelled.
+func shutdown(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("could not gracefully shut down: %v", err)
+	}
+}
+
 func initUserPass() {
 	user, err := randToken(20)
 	if err != nil {
@@ -220,24 +433,76 @@ func main() {
 		usage()
 	}
 	initUserPass()
+	initListingTemplate()
 	http.Handle("/", makeHandler(myFileServer))
 	port, err := randPort()
 	if err != nil {
 		log.Fatal(err)
 	}
 	hostPort := fmt.Sprintf("%v:%v", *flagHost, port)
+
+	var tlsConfig *tls.Config
+	if *flagAutocert != "" {
+		var m *autocert.Manager
+		tlsConfig, m, err = buildAutocertConfig(*flagAutocert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := http.ListenAndServe(*flagAutocertAddr, m.HTTPHandler(nil)); err != nil {
+				log.Printf("ACME HTTP-01 challenge helper on %v failed: %v", *flagAutocertAddr, err)
+			}
+		}()
+	} else {
+		tlsConfig, err = buildTLSConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	srv := &http.Server{
+		Addr:      hostPort,
+		TLSConfig: tlsConfig,
+	}
+	if err := http2.ConfigureServer(srv, nil); err != nil {
+		log.Fatal(err)
+	}
+
+	var once sync.Once
+	shutdownDone := make(chan struct{})
+	stop := func() {
+		once.Do(func() {
+			shutdown(srv)
+			close(shutdownDone)
+		})
+	}
 	t := time.AfterFunc(*flagDie, func() {
 		log.Printf("Server lifetime of %v is over, calling it quits now.", *flagDie)
-		os.Exit(0)
+		stop()
 	})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("received %v, shutting down", sig)
+		t.Stop()
+		stop()
+	}()
+
+	ln, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagMaxConns > 0 {
+		ln = netutil.LimitListener(ln, *flagMaxConns)
+	}
+
 	println("Starting to listen on: https://" + hostPort)
 	fmt.Printf("Server will die in %v\n", *flagDie)
-	if err := http.ListenAndServeTLS(
-		hostPort,
-		filepath.Join(os.Getenv("HOME"), "keys", "cert.pem"),
-		filepath.Join(os.Getenv("HOME"), "keys", "key.pem"),
-		nil); err != nil {
+	if err := srv.Serve(tls.NewListener(ln, tlsConfig)); err != nil && err != http.ErrServerClosed {
 		t.Stop()
 		log.Fatal(err)
 	}
+	// Serve returns as soon as Shutdown begins, so wait for shutdown to
+	// finish with the in-flight requests before exiting.
+	<-shutdownDone
 }
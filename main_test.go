@@ -0,0 +1,83 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseMinTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{"tls1.0", tls.VersionTLS10, false},
+		{"tls1.2", tls.VersionTLS12, false},
+		{"tls1.3", tls.VersionTLS13, false},
+		{"tls1.4", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMinTLSVersion(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseMinTLSVersion(%q): want error, got none", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMinTLSVersion(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMinTLSVersion(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := parseCipherSuites("")
+	if err != nil || got != nil {
+		t.Errorf("parseCipherSuites(\"\") = %v, %v, want nil, nil", got, err)
+	}
+
+	got, err = parseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_AES_128_GCM_SHA256")
+	if err != nil {
+		t.Fatalf("parseCipherSuites: unexpected error: %v", err)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_AES_128_GCM_SHA256}
+	if len(got) != len(want) {
+		t.Fatalf("parseCipherSuites = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseCipherSuites[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := parseCipherSuites("TLS_NOT_A_REAL_SUITE"); err == nil {
+		t.Error("parseCipherSuites(unknown suite): want error, got none")
+	}
+}
+
+func TestCheckHTTP2CipherSuites(t *testing.T) {
+	tests := []struct {
+		name       string
+		minVersion uint16
+		suites     []uint16
+		wantErr    bool
+	}{
+		{"no suites set", tls.VersionTLS12, nil, false},
+		{"tls1.3 ignores CipherSuites", tls.VersionTLS13, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, false},
+		{"includes required suite", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, false},
+		{"missing required suite", tls.VersionTLS12, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256}, true},
+	}
+	for _, tt := range tests {
+		err := checkHTTP2CipherSuites(tt.minVersion, tt.suites)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%s: checkHTTP2CipherSuites(%v, %v) error = %v, wantErr %v", tt.name, tt.minVersion, tt.suites, err, tt.wantErr)
+		}
+	}
+}
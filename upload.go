@@ -0,0 +1,75 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// sha256Dir is, relative to the upload directory, where uploaded content is
+// actually stored, named after its hex-encoded sha256 digest.
+const sha256Dir = "sha256"
+
+// effectiveUploadDir returns -uploadDir if set, or else rootdir.
+func effectiveUploadDir() string {
+	if *flagUploadDir != "" {
+		return *flagUploadDir
+	}
+	return rootdir
+}
+
+// handleUpload stores the body of r under a content-addressed path derived
+// from its sha256 digest, symlinks name to it for convenience, and reports
+// the digest back to the sender so they can verify what was received.
+func handleUpload(w http.ResponseWriter, r *http.Request, name string) {
+	dir := effectiveUploadDir()
+	casDir := filepath.Join(dir, sha256Dir)
+	if err := os.MkdirAll(casDir, 0700); err != nil {
+		http.Error(w, fmt.Sprintf("could not create %v: %v", casDir, err), http.StatusInternalServerError)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(casDir, ".upload-")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not create temp file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r.Body); err != nil {
+		tmp.Close()
+		http.Error(w, fmt.Sprintf("could not read upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("could not finalize upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	dest := filepath.Join(casDir, digest)
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		http.Error(w, fmt.Sprintf("could not store upload as %v: %v", dest, err), http.StatusInternalServerError)
+		return
+	}
+
+	if base := filepath.Base(name); base != "" && base != "." && base != "/" {
+		link := filepath.Join(dir, base)
+		os.Remove(link)
+		if err := os.Symlink(filepath.Join(sha256Dir, digest), link); err != nil {
+			log.Printf("could not symlink %v to uploaded %v: %v", link, digest, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "sha256:%s\n", digest)
+}
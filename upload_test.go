@@ -0,0 +1,82 @@
+// Copyright 2016 Mathieu Lonjaret
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fafserver-upload-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := *flagUploadDir
+	*flagUploadDir = dir
+	defer func() { *flagUploadDir = old }()
+
+	body := []byte("hello world")
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+
+	req := httptest.NewRequest("PUT", "/report.txt", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handleUpload(w, req, "/report.txt")
+
+	if got, want := w.Body.String(), "sha256:"+digest+"\n"; got != want {
+		t.Fatalf("response = %q, want %q", got, want)
+	}
+
+	casPath := filepath.Join(dir, sha256Dir, digest)
+	got, err := ioutil.ReadFile(casPath)
+	if err != nil {
+		t.Fatalf("CAS file %v: %v", casPath, err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("CAS file content = %q, want %q", got, body)
+	}
+
+	link := filepath.Join(dir, "report.txt")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("Readlink(%v): %v", link, err)
+	}
+	if want := filepath.Join(sha256Dir, digest); target != want {
+		t.Errorf("symlink target = %q, want %q", target, want)
+	}
+
+	// A second upload under the same name should replace the symlink, not
+	// the first upload's CAS blob.
+	body2 := []byte("a different body")
+	sum2 := sha256.Sum256(body2)
+	digest2 := hex.EncodeToString(sum2[:])
+
+	req2 := httptest.NewRequest("PUT", "/report.txt", bytes.NewReader(body2))
+	w2 := httptest.NewRecorder()
+	handleUpload(w2, req2, "/report.txt")
+
+	if got, want := w2.Body.String(), "sha256:"+digest2+"\n"; got != want {
+		t.Fatalf("second response = %q, want %q", got, want)
+	}
+
+	target2, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("Readlink(%v) after second upload: %v", link, err)
+	}
+	if want := filepath.Join(sha256Dir, digest2); target2 != want {
+		t.Errorf("symlink target after second upload = %q, want %q", target2, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, sha256Dir, digest)); err != nil {
+		t.Errorf("first upload's CAS blob should still exist untouched, got: %v", err)
+	}
+}